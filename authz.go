@@ -0,0 +1,285 @@
+package samlplugin
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// AttrExpr is a boolean expression over a SAML attribute set. It is the
+// common evaluator behind both the Caddyfile `require` directive embedded
+// in a Route and the programmatic RequireAttributeExpr middleware, so an
+// expression means the same thing whether it comes from config or code.
+type AttrExpr interface {
+	Eval(attrs map[string][]string) bool
+}
+
+type notExpr struct{ expr AttrExpr }
+
+func (e notExpr) Eval(attrs map[string][]string) bool { return !e.expr.Eval(attrs) }
+
+type andExpr struct{ left, right AttrExpr }
+
+func (e andExpr) Eval(attrs map[string][]string) bool {
+	return e.left.Eval(attrs) && e.right.Eval(attrs)
+}
+
+type orExpr struct{ left, right AttrExpr }
+
+func (e orExpr) Eval(attrs map[string][]string) bool {
+	return e.left.Eval(attrs) || e.right.Eval(attrs)
+}
+
+// inExpr matches when attrs[name] contains a value matching one of the
+// patterns, where a pattern may use `*`/`?` wildcards (see path.Match).
+type inExpr struct {
+	name     string
+	patterns []string
+}
+
+func (e inExpr) Eval(attrs map[string][]string) bool {
+	for _, actual := range attrs[e.name] {
+		for _, pattern := range e.patterns {
+			if attrValueMatches(pattern, actual) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsExpr matches when any value of attrs[name] contains substr.
+type containsExpr struct {
+	name   string
+	substr string
+}
+
+func (e containsExpr) Eval(attrs map[string][]string) bool {
+	for _, actual := range attrs[e.name] {
+		if strings.Contains(actual, e.substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func attrValueMatches(pattern, actual string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == actual
+	}
+	ok, err := path.Match(pattern, actual)
+	return err == nil && ok
+}
+
+// ParseAttrExpr parses a boolean attribute expression of the form used by
+// the Caddyfile `require` directive, e.g.:
+//
+//	eduPersonAffiliation in [staff,faculty] and !groups contains "banned"
+//
+// Grammar (lowest to highest precedence):
+//
+//	expr       := orTerm ("or" orTerm)*
+//	orTerm     := andTerm ("and" andTerm)*
+//	andTerm    := ("!" | "not") andTerm | primary
+//	primary    := "(" expr ")" | comparison
+//	comparison := NAME "in" "[" value ("," value)* "]"
+//	            | NAME "contains" value
+//	            | NAME "=" value
+//	value      := STRING | IDENT
+//
+// Values in an `in` list or after `=`, quoted or not, may contain `*`/`?`
+// wildcards, matched per path.Match. Attribute names are matched exactly,
+// and a `contains` value is matched as a literal substring.
+func ParseAttrExpr(expr string) (AttrExpr, error) {
+	p := &exprParser{tokens: tokenizeExpr(expr)}
+	result, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q in expression %q", p.tokens[p.pos], expr)
+	}
+	return result, nil
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *exprParser) parseOr() (AttrExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (AttrExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (AttrExpr, error) {
+	if tok := p.peek(); tok == "!" || strings.EqualFold(tok, "not") {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (AttrExpr, error) {
+	if p.peek() == "(" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("expected ) in expression")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (AttrExpr, error) {
+	name := p.next()
+	if name == "" {
+		return nil, fmt.Errorf("expected attribute name")
+	}
+
+	switch op := p.next(); {
+	case strings.EqualFold(op, "in"):
+		if p.next() != "[" {
+			return nil, fmt.Errorf("expected [ after %q in", name)
+		}
+		var patterns []string
+		if p.peek() == "]" {
+			p.next()
+			return inExpr{name: name, patterns: patterns}, nil
+		}
+		for {
+			patterns = append(patterns, unquote(p.next()))
+			switch p.next() {
+			case ",":
+				continue
+			case "]":
+			default:
+				return nil, fmt.Errorf("expected , or ] in %q in-list", name)
+			}
+			break
+		}
+		return inExpr{name: name, patterns: patterns}, nil
+
+	case strings.EqualFold(op, "contains"):
+		return containsExpr{name: name, substr: unquote(p.next())}, nil
+
+	case op == "=":
+		return inExpr{name: name, patterns: []string{unquote(p.next())}}, nil
+
+	default:
+		return nil, fmt.Errorf("expected in/contains/= after attribute name %q, got %q", name, op)
+	}
+}
+
+func unquote(tok string) string {
+	if len(tok) >= 2 && tok[0] == '"' && tok[len(tok)-1] == '"' {
+		return tok[1 : len(tok)-1]
+	}
+	return tok
+}
+
+// tokenizeExpr splits an attribute expression into identifiers, quoted
+// strings (kept with their surrounding quotes, stripped by unquote), and
+// the punctuation ( ) [ ] , !.
+func tokenizeExpr(expr string) []string {
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		switch c := runes[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == ',' || c == '!':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				j++
+			}
+			if j < len(runes) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !strings.ContainsRune(" \t\n()[],!", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return tokens
+}
+
+// RequireAttributeExpr is the expression-based counterpart to
+// RequireAttribute: it returns middleware that requires expr to evaluate
+// true against the authenticated request's attributes, using the same
+// evaluator as a Route's `require` directive. It relies on the Claims
+// assigned to the context in RequireAccount.
+func RequireAttributeExpr(expr string) (func(http.Handler) http.Handler, error) {
+	parsed, err := ParseAttrExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(handler http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if claims := Token(r.Context()); claims != nil && parsed.Eval(claims.Attributes) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
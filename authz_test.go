@@ -0,0 +1,100 @@
+package samlplugin
+
+import "testing"
+
+func TestParseAttrExprEquality(t *testing.T) {
+	expr, err := ParseAttrExpr(`role = admin`)
+	if err != nil {
+		t.Fatalf("ParseAttrExpr: %s", err)
+	}
+	if !expr.Eval(map[string][]string{"role": {"admin"}}) {
+		t.Error("expected match on role=admin")
+	}
+	if expr.Eval(map[string][]string{"role": {"user"}}) {
+		t.Error("expected no match on role=user")
+	}
+}
+
+func TestParseAttrExprInList(t *testing.T) {
+	expr, err := ParseAttrExpr(`role in [admin, owner]`)
+	if err != nil {
+		t.Fatalf("ParseAttrExpr: %s", err)
+	}
+	if !expr.Eval(map[string][]string{"role": {"owner"}}) {
+		t.Error("expected match on role=owner")
+	}
+	if expr.Eval(map[string][]string{"role": {"user"}}) {
+		t.Error("expected no match on role=user")
+	}
+}
+
+func TestParseAttrExprInEmptyList(t *testing.T) {
+	expr, err := ParseAttrExpr(`role in []`)
+	if err != nil {
+		t.Fatalf("ParseAttrExpr: %s", err)
+	}
+	if expr.Eval(map[string][]string{"role": {"admin"}}) {
+		t.Error("empty in-list should never match")
+	}
+}
+
+func TestParseAttrExprWildcard(t *testing.T) {
+	expr, err := ParseAttrExpr(`email in ["*@example.com"]`)
+	if err != nil {
+		t.Fatalf("ParseAttrExpr: %s", err)
+	}
+	if !expr.Eval(map[string][]string{"email": {"alice@example.com"}}) {
+		t.Error("expected wildcard match")
+	}
+	if expr.Eval(map[string][]string{"email": {"alice@other.com"}}) {
+		t.Error("expected no wildcard match")
+	}
+}
+
+func TestParseAttrExprContains(t *testing.T) {
+	expr, err := ParseAttrExpr(`groups contains eng`)
+	if err != nil {
+		t.Fatalf("ParseAttrExpr: %s", err)
+	}
+	if !expr.Eval(map[string][]string{"groups": {"eng-platform"}}) {
+		t.Error("expected substring match")
+	}
+	if expr.Eval(map[string][]string{"groups": {"sales"}}) {
+		t.Error("expected no substring match")
+	}
+}
+
+func TestParseAttrExprAndOrNot(t *testing.T) {
+	expr, err := ParseAttrExpr(`role = admin and (team = core or not region = eu)`)
+	if err != nil {
+		t.Fatalf("ParseAttrExpr: %s", err)
+	}
+	cases := []struct {
+		attrs map[string][]string
+		want  bool
+	}{
+		{map[string][]string{"role": {"admin"}, "team": {"core"}, "region": {"eu"}}, true},
+		{map[string][]string{"role": {"admin"}, "team": {"other"}, "region": {"us"}}, true},
+		{map[string][]string{"role": {"admin"}, "team": {"other"}, "region": {"eu"}}, false},
+		{map[string][]string{"role": {"user"}, "team": {"core"}, "region": {"us"}}, false},
+	}
+	for _, c := range cases {
+		if got := expr.Eval(c.attrs); got != c.want {
+			t.Errorf("Eval(%v) = %v, want %v", c.attrs, got, c.want)
+		}
+	}
+}
+
+func TestParseAttrExprSyntaxErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`role in admin]`,
+		`role in [admin`,
+		`role`,
+		`role = admin extra`,
+	} {
+		if _, err := ParseAttrExpr(expr); err == nil {
+			t.Errorf("ParseAttrExpr(%q): expected error, got nil", expr)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package samlplugin
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// defaultMetadataRefreshInterval is used to re-fetch remote IdP metadata
+// when the metadata document itself specifies neither cacheDuration nor
+// validUntil.
+const defaultMetadataRefreshInterval = 24 * time.Hour
+
+// FetchIDPMetadata retrieves and parses the IdP's EntityDescriptor from
+// url, for use as ServiceProvider.IDPMetadata. It is called once at startup
+// for `idp_metadata_url` configurations, and again on each tick of
+// WatchIDPMetadata thereafter.
+func FetchIDPMetadata(httpClient *http.Client, url string) (*saml.EntityDescriptor, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching IdP metadata: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching IdP metadata: unexpected status %s", resp.Status)
+	}
+
+	metadata := &saml.EntityDescriptor{}
+	if err := xml.NewDecoder(resp.Body).Decode(metadata); err != nil {
+		return nil, fmt.Errorf("parsing IdP metadata: %s", err)
+	}
+	return metadata, nil
+}
+
+// metadataRefreshInterval returns how long to wait before re-fetching
+// metadata, preferring validUntil over cacheDuration and falling back to
+// defaultMetadataRefreshInterval when the document specifies neither.
+func metadataRefreshInterval(metadata *saml.EntityDescriptor, now time.Time) time.Duration {
+	if !metadata.ValidUntil.IsZero() {
+		if d := metadata.ValidUntil.Sub(now); d > 0 {
+			return d
+		}
+	}
+	if metadata.CacheDuration != 0 {
+		return metadata.CacheDuration
+	}
+	return defaultMetadataRefreshInterval
+}
+
+// idpMetadata returns the currently active IdP metadata. All reads of
+// s.ServiceProvider.IDPMetadata from our own code should go through this
+// accessor rather than touching the field directly, so they're guarded
+// against a concurrent WatchIDPMetadata refresh by the same mutex.
+func (s *SAMLPlugin) idpMetadata() *saml.EntityDescriptor {
+	s.idpMetadataMu.RLock()
+	defer s.idpMetadataMu.RUnlock()
+	return s.ServiceProvider.IDPMetadata
+}
+
+// setIDPMetadata swaps in newly-fetched IdP metadata, guarded against
+// concurrent reads via idpMetadata.
+func (s *SAMLPlugin) setIDPMetadata(metadata *saml.EntityDescriptor) {
+	s.idpMetadataMu.Lock()
+	defer s.idpMetadataMu.Unlock()
+	s.ServiceProvider.IDPMetadata = metadata
+}
+
+// serviceProvider returns a consistent snapshot of s.ServiceProvider, safe
+// to use even while WatchIDPMetadata concurrently swaps in new metadata via
+// setIDPMetadata. Vendored saml.ServiceProvider methods like ParseResponse
+// read sp.IDPMetadata directly and can't be made to go through idpMetadata()
+// themselves, so call sites on the hot (e.g. ACS) path should take a
+// snapshot with this instead of touching s.ServiceProvider directly.
+func (s *SAMLPlugin) serviceProvider() saml.ServiceProvider {
+	s.idpMetadataMu.RLock()
+	defer s.idpMetadataMu.RUnlock()
+	return s.ServiceProvider
+}
+
+// getIDPSigningCerts extracts the IdP's signing certificates from the
+// currently active metadata (as returned by idpMetadata), for use when
+// validating an incoming LogoutRequest's signature.
+func (s *SAMLPlugin) getIDPSigningCerts() ([]*x509.Certificate, error) {
+	metadata := s.idpMetadata()
+	if metadata == nil {
+		return nil, fmt.Errorf("no IdP metadata loaded")
+	}
+
+	var certs []*x509.Certificate
+	for _, idpSSODescriptor := range metadata.IDPSSODescriptors {
+		for _, keyDescriptor := range idpSSODescriptor.KeyDescriptors {
+			if keyDescriptor.Use != "" && keyDescriptor.Use != "signing" {
+				continue
+			}
+			for _, certData := range keyDescriptor.KeyInfo.X509Data.X509Certificates {
+				der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(certData.Data))
+				if err != nil {
+					return nil, fmt.Errorf("decoding IdP signing certificate: %s", err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("parsing IdP signing certificate: %s", err)
+				}
+				certs = append(certs, cert)
+			}
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("IdP metadata has no signing certificates")
+	}
+	return certs, nil
+}
+
+// WatchIDPMetadata re-fetches the IdP metadata at url on the interval the
+// metadata itself advertises (falling back to defaultMetadataRefreshInterval),
+// swapping it into s.ServiceProvider.IDPMetadata via setIDPMetadata so that
+// IdP key rollovers take effect without a Caddy restart, without racing
+// concurrent reads through idpMetadata/getIDPSigningCerts. A failed refresh
+// is logged and retried on the next tick; the previously loaded metadata
+// remains in effect (fail-open). It runs until stop is closed.
+func (s *SAMLPlugin) WatchIDPMetadata(httpClient *http.Client, url string, stop <-chan struct{}) {
+	for {
+		interval := defaultMetadataRefreshInterval
+		if metadata := s.idpMetadata(); metadata != nil {
+			interval = metadataRefreshInterval(metadata, saml.TimeNow())
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+
+		metadata, err := FetchIDPMetadata(httpClient, url)
+		if err != nil {
+			s.ServiceProvider.Logger.Printf("failed to refresh IdP metadata from %s: %s", url, err)
+			continue
+		}
+		s.setIDPMetadata(metadata)
+	}
+}
@@ -0,0 +1,132 @@
+package samlplugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+const testMetadataXML = `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com/metadata"></EntityDescriptor>`
+
+func TestFetchIDPMetadataSuccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testMetadataXML)
+	}))
+	defer server.Close()
+
+	metadata, err := FetchIDPMetadata(nil, server.URL)
+	if err != nil {
+		t.Fatalf("FetchIDPMetadata: %s", err)
+	}
+	if metadata.EntityID != "https://idp.example.com/metadata" {
+		t.Errorf("EntityID = %q, want %q", metadata.EntityID, "https://idp.example.com/metadata")
+	}
+}
+
+func TestFetchIDPMetadataHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if _, err := FetchIDPMetadata(nil, server.URL); err == nil {
+		t.Error("expected an error fetching metadata from a failing endpoint")
+	}
+}
+
+func TestFetchIDPMetadataMalformedXML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "not xml")
+	}))
+	defer server.Close()
+
+	if _, err := FetchIDPMetadata(nil, server.URL); err == nil {
+		t.Error("expected an error parsing malformed metadata")
+	}
+}
+
+func TestFetchIDPMetadataUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	if _, err := FetchIDPMetadata(nil, server.URL); err == nil {
+		t.Error("expected an error fetching metadata from an unreachable endpoint")
+	}
+}
+
+func TestMetadataRefreshIntervalPrefersValidUntil(t *testing.T) {
+	now := time.Now()
+	metadata := &saml.EntityDescriptor{ValidUntil: now.Add(2 * time.Hour)}
+	got := metadataRefreshInterval(metadata, now)
+	want := 2 * time.Hour
+	if got <= want-time.Second || got > want {
+		t.Errorf("metadataRefreshInterval = %s, want ~%s", got, want)
+	}
+}
+
+func TestMetadataRefreshIntervalFallsBackToCacheDuration(t *testing.T) {
+	metadata := &saml.EntityDescriptor{CacheDuration: 30 * time.Minute}
+	if got := metadataRefreshInterval(metadata, time.Now()); got != 30*time.Minute {
+		t.Errorf("metadataRefreshInterval = %s, want %s", got, 30*time.Minute)
+	}
+}
+
+func TestMetadataRefreshIntervalFallsBackToDefault(t *testing.T) {
+	metadata := &saml.EntityDescriptor{}
+	if got := metadataRefreshInterval(metadata, time.Now()); got != defaultMetadataRefreshInterval {
+		t.Errorf("metadataRefreshInterval = %s, want %s", got, defaultMetadataRefreshInterval)
+	}
+}
+
+func TestMetadataRefreshIntervalIgnoresExpiredValidUntil(t *testing.T) {
+	now := time.Now()
+	metadata := &saml.EntityDescriptor{ValidUntil: now.Add(-time.Hour), CacheDuration: 10 * time.Minute}
+	if got := metadataRefreshInterval(metadata, now); got != 10*time.Minute {
+		t.Errorf("metadataRefreshInterval = %s, want %s (CacheDuration fallback)", got, 10*time.Minute)
+	}
+}
+
+// TestIDPMetadataConcurrentRefresh exercises setIDPMetadata racing against
+// idpMetadata and serviceProvider reads, guarding against the ACS hot path
+// (serviceProvider) or the logout path (idpMetadata/getIDPSigningCerts)
+// observing a torn read while WatchIDPMetadata swaps in new metadata.
+func TestIDPMetadataConcurrentRefresh(t *testing.T) {
+	s := &SAMLPlugin{}
+	s.setIDPMetadata(&saml.EntityDescriptor{EntityID: "initial"})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				s.setIDPMetadata(&saml.EntityDescriptor{EntityID: fmt.Sprintf("refresh-%d", i)})
+			}
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				_ = s.idpMetadata()
+				_ = s.serviceProvider()
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,116 @@
+package samlplugin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/crewjam/saml"
+	"github.com/dgrijalva/jwt-go"
+)
+
+func generateTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test RSA key: %s", err)
+	}
+	return key
+}
+
+func TestSigningKeyDefaultsToServiceProviderKey(t *testing.T) {
+	spKey := generateTestKey(t)
+	s := &SAMLPlugin{ServiceProvider: saml.ServiceProvider{Key: spKey}}
+
+	if s.signingKey() != spKey {
+		t.Error("signingKey should default to ServiceProvider.Key when JWTKey is unset")
+	}
+}
+
+func TestSigningKeyPrefersJWTKey(t *testing.T) {
+	spKey := generateTestKey(t)
+	jwtKey := generateTestKey(t)
+	s := &SAMLPlugin{
+		ServiceProvider: saml.ServiceProvider{Key: spKey},
+		JWTKey:          jwtKey,
+	}
+
+	if s.signingKey() != jwtKey {
+		t.Error("signingKey should prefer JWTKey when set")
+	}
+}
+
+func TestSignJWTRoundTrip(t *testing.T) {
+	key := generateTestKey(t)
+	s := &SAMLPlugin{ServiceProvider: saml.ServiceProvider{Key: key}}
+
+	tokenStr, err := s.signJWT(&jwt.StandardClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("signJWT: %s", err)
+	}
+
+	claims := &jwt.StandardClaims{}
+	if _, err := restrictedJWTParser.ParseWithClaims(tokenStr, claims, s.jwtKeyFunc); err != nil {
+		t.Fatalf("ParseWithClaims: %s", err)
+	}
+	if claims.Subject != "alice" {
+		t.Errorf("Subject = %q, want %q", claims.Subject, "alice")
+	}
+}
+
+// TestJWTKeyFuncRotationWithKid verifies that a token signed under an old
+// key and kid still verifies after JWTKey/JWTKeyID have been rotated to a
+// new key, as long as the old key's public half remains in
+// JWTVerificationKeys under its old kid.
+func TestJWTKeyFuncRotationWithKid(t *testing.T) {
+	oldKey := generateTestKey(t)
+	s := &SAMLPlugin{JWTKey: oldKey, JWTKeyID: "old"}
+
+	tokenStr, err := s.signJWT(&jwt.StandardClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("signJWT: %s", err)
+	}
+
+	newKey := generateTestKey(t)
+	s.JWTKey = newKey
+	s.JWTKeyID = "new"
+	s.JWTVerificationKeys = map[string]*rsa.PublicKey{"old": &oldKey.PublicKey}
+
+	claims := &jwt.StandardClaims{}
+	if _, err := restrictedJWTParser.ParseWithClaims(tokenStr, claims, s.jwtKeyFunc); err != nil {
+		t.Fatalf("ParseWithClaims after rotation: %s", err)
+	}
+}
+
+// TestJWTKeyFuncWithoutKidFallsBackToCurrentKey verifies that a token with
+// no kid header (e.g. issued before JWTKeyID was configured) falls back to
+// verifying against the current signing key's public half, rather than
+// failing outright.
+func TestJWTKeyFuncWithoutKidFallsBackToCurrentKey(t *testing.T) {
+	key := generateTestKey(t)
+	s := &SAMLPlugin{ServiceProvider: saml.ServiceProvider{Key: key}}
+
+	tokenStr, err := s.signJWT(&jwt.StandardClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("signJWT: %s", err)
+	}
+
+	claims := &jwt.StandardClaims{}
+	if _, err := restrictedJWTParser.ParseWithClaims(tokenStr, claims, s.jwtKeyFunc); err != nil {
+		t.Fatalf("ParseWithClaims: %s", err)
+	}
+}
+
+func TestRestrictedJWTParserRejectsOtherSigningMethods(t *testing.T) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{Subject: "alice"})
+	tokenStr, err := token.SignedString([]byte("not-the-real-key"))
+	if err != nil {
+		t.Fatalf("SignedString: %s", err)
+	}
+
+	s := &SAMLPlugin{ServiceProvider: saml.ServiceProvider{Key: generateTestKey(t)}}
+	claims := &jwt.StandardClaims{}
+	if _, err := restrictedJWTParser.ParseWithClaims(tokenStr, claims, s.jwtKeyFunc); err == nil {
+		t.Error("expected ParseWithClaims to reject a token signed with an unexpected method")
+	}
+}
@@ -0,0 +1,184 @@
+package samlplugin
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/crewjam/saml"
+)
+
+// sloBindingLocation resolves the binding and binding location to use for a
+// new logout request, honoring s.Binding when set and otherwise preferring
+// HTTP-Redirect, falling back to HTTP-POST, mirroring ssoBindingLocation.
+func (s *SAMLPlugin) sloBindingLocation() (binding, bindingLocation string) {
+	if s.Binding != "" {
+		return s.Binding, s.ServiceProvider.GetSLOBindingLocation(s.Binding)
+	}
+	binding = saml.HTTPRedirectBinding
+	bindingLocation = s.ServiceProvider.GetSLOBindingLocation(binding)
+	if bindingLocation == "" {
+		binding = saml.HTTPPostBinding
+		bindingLocation = s.ServiceProvider.GetSLOBindingLocation(binding)
+	}
+	return binding, bindingLocation
+}
+
+// responseBindingFor picks the binding to answer an incoming LogoutRequest
+// with: HTTP-POST requests are answered over HTTP-POST, everything else
+// (a GET carrying the HTTP-Redirect binding) is answered over HTTP-Redirect.
+// Answering on the same binding the request arrived on, rather than on
+// whatever binding s.Binding/sloBindingLocation independently prefers, keeps
+// the LogoutResponse's Destination consistent with how it's delivered.
+func responseBindingFor(r *http.Request) string {
+	if r.Method == http.MethodPost {
+		return saml.HTTPPostBinding
+	}
+	return saml.HTTPRedirectBinding
+}
+
+// handleSLO dispatches Single Logout requests arriving at s.SLOURL.Path. The
+// SAML SLO profile is bidirectional: an IdP may POST or redirect a
+// LogoutRequest to us (IdP-initiated logout), or the IdP may answer a
+// LogoutRequest we sent with a LogoutResponse (SP-initiated logout,
+// completing the flow started by InitiateLogout).
+func (s *SAMLPlugin) handleSLO(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	if r.Form.Get("SAMLRequest") != "" {
+		s.handleLogoutRequest(w, r)
+		return
+	}
+
+	if r.Form.Get("SAMLResponse") != "" {
+		s.handleLogoutResponse(w, r)
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+}
+
+// InitiateLogout begins SP-initiated Single Logout for the account
+// associated with r. It builds and signs a LogoutRequest addressed to the
+// IdP's SLO binding location, using the same redirect/POST binding
+// selection logic as RequireAccount, then clears the local session state.
+func (s *SAMLPlugin) InitiateLogout(w http.ResponseWriter, r *http.Request) {
+	token := s.GetAuthorizationToken(r)
+	if token == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	binding, bindingLocation := s.sloBindingLocation()
+	if bindingLocation == "" {
+		http.Error(w, "IdP does not support Single Logout", http.StatusNotImplemented)
+		return
+	}
+
+	logoutReq, err := s.ServiceProvider.MakeLogoutRequest(bindingLocation, token.Subject)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logoutReq.SessionIndex = token.SessionIndex
+
+	relayState := base64.URLEncoding.EncodeToString(randomBytes(42))
+
+	s.clearSession(w, r)
+
+	if binding == saml.HTTPRedirectBinding {
+		redirectURL := logoutReq.Redirect(relayState)
+		w.Header().Add("Location", redirectURL.String())
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
+	w.Header().Add("Content-type", "text/html")
+	w.Write([]byte(`<!DOCTYPE html><html><body>`))
+	w.Write(logoutReq.Post(relayState))
+	w.Write([]byte(`</body></html>`))
+}
+
+// logoutRequestMatchesSession reports whether logoutReq's NameID and
+// SessionIndex identify the same session as token, so handleLogoutRequest
+// only clears a session the LogoutRequest actually names rather than
+// trusting its signature alone to authorize clearing whatever session
+// happens to be active.
+func logoutRequestMatchesSession(token *AuthorizationToken, logoutReq *incomingLogoutRequest) bool {
+	return token != nil && token.Subject == logoutReq.NameID.Value && token.SessionIndex == logoutReq.SessionIndex
+}
+
+// handleLogoutRequest handles an IdP-initiated LogoutRequest: it validates
+// the request's signature, NameID and SessionIndex against the current
+// session (if any), clears that session, and responds with a signed
+// LogoutResponse over the same binding the request arrived on.
+func (s *SAMLPlugin) handleLogoutRequest(w http.ResponseWriter, r *http.Request) {
+	logoutReq, err := s.parseLogoutRequest(r)
+	if err != nil {
+		s.ServiceProvider.Logger.Printf("invalid LogoutRequest: %s", err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if logoutRequestMatchesSession(s.GetAuthorizationToken(r), logoutReq) {
+		s.clearSession(w, r)
+	}
+
+	// Derive idpURL from the same binding we're about to respond with, not
+	// from sloBindingLocation's independently-configured preference: the
+	// response's Destination must match how we actually deliver it, or a
+	// strict IdP will reject it as a Destination mismatch.
+	binding := responseBindingFor(r)
+	idpURL := s.ServiceProvider.GetSLOBindingLocation(binding)
+
+	relayState := r.Form.Get("RelayState")
+	logoutResp, err := s.ServiceProvider.MakeLogoutResponse(idpURL, logoutReq.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if binding == saml.HTTPRedirectBinding {
+		redirectURL := logoutResp.Redirect(relayState)
+		w.Header().Add("Location", redirectURL.String())
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+	w.Header().Add("Content-type", "text/html")
+	w.Write([]byte(`<!DOCTYPE html><html><body>`))
+	w.Write(logoutResp.Post(relayState))
+	w.Write([]byte(`</body></html>`))
+}
+
+// handleLogoutResponse completes SP-initiated logout: the IdP has answered
+// a LogoutRequest we sent via InitiateLogout. We validate the response and,
+// regardless of outcome, the session was already cleared when the logout
+// was initiated.
+func (s *SAMLPlugin) handleLogoutResponse(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if r.Method == http.MethodPost {
+		err = s.ServiceProvider.ValidateLogoutResponseForm(r.Form.Get("SAMLResponse"))
+	} else {
+		err = s.ServiceProvider.ValidateLogoutResponseRedirect(r.URL.RawQuery)
+	}
+	if err != nil {
+		s.ServiceProvider.Logger.Printf("invalid LogoutResponse: %s", err)
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// clearSession removes the session cookie managed by ClientToken, the
+// corresponding server-side session when a SessionStore is configured, and
+// any outstanding saml_* relay-state cookies managed by ClientState.
+func (s *SAMLPlugin) clearSession(w http.ResponseWriter, r *http.Request) {
+	if s.SessionStore != nil {
+		if tokenStr := s.ClientToken.GetToken(r); tokenStr != "" {
+			pointer := sessionCookieClaims{}
+			_, _ = restrictedJWTParser.ParseWithClaims(tokenStr, &pointer, s.jwtKeyFunc)
+			if pointer.SessionID != "" {
+				s.SessionStore.Delete(pointer.SessionID)
+			}
+		}
+	}
+	s.ClientToken.DeleteToken(w, r)
+	s.ClientState.DeleteStates(w, r)
+}
@@ -0,0 +1,190 @@
+package samlplugin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+func generateTestCert(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating test certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing test certificate: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func testIDPMetadata(certs ...*x509.Certificate) *saml.EntityDescriptor {
+	var keyDescriptors []saml.KeyDescriptor
+	for _, cert := range certs {
+		keyDescriptors = append(keyDescriptors, saml.KeyDescriptor{
+			Use: "signing",
+			KeyInfo: saml.KeyInfo{
+				X509Data: saml.X509Data{
+					X509Certificates: []saml.X509Certificate{{
+						Data: base64.StdEncoding.EncodeToString(cert.Raw),
+					}},
+				},
+			},
+		})
+	}
+	return &saml.EntityDescriptor{
+		IDPSSODescriptors: []saml.IDPSSODescriptor{{KeyDescriptors: keyDescriptors}},
+	}
+}
+
+func unsignedLogoutRequestElement(id, nameID, sessionIndex string) *etree.Element {
+	root := etree.NewElement("samlp:LogoutRequest")
+	root.CreateAttr("xmlns:samlp", "urn:oasis:names:tc:SAML:2.0:protocol")
+	root.CreateAttr("xmlns:saml", "urn:oasis:names:tc:SAML:2.0:assertion")
+	root.CreateAttr("ID", id)
+	root.CreateAttr("Version", "2.0")
+	root.CreateAttr("IssueInstant", time.Now().UTC().Format(time.RFC3339))
+	root.CreateElement("saml:NameID").SetText(nameID)
+	if sessionIndex != "" {
+		root.CreateElement("samlp:SessionIndex").SetText(sessionIndex)
+	}
+	return root
+}
+
+func signedLogoutRequestXML(t *testing.T, signer tls.Certificate, id, nameID, sessionIndex string) []byte {
+	t.Helper()
+	ctx := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(signer))
+	signed, err := ctx.SignEnveloped(unsignedLogoutRequestElement(id, nameID, sessionIndex))
+	if err != nil {
+		t.Fatalf("signing LogoutRequest: %s", err)
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(signed)
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatalf("serializing signed LogoutRequest: %s", err)
+	}
+	return raw
+}
+
+func logoutRequestHTTPRequest(t *testing.T, raw []byte) *http.Request {
+	t.Helper()
+	form := url.Values{"SAMLRequest": {base64.StdEncoding.EncodeToString(raw)}}
+	req := httptest.NewRequest(http.MethodPost, "/saml/slo", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := req.ParseForm(); err != nil {
+		t.Fatalf("ParseForm: %s", err)
+	}
+	return req
+}
+
+func TestParseLogoutRequestValidSignature(t *testing.T) {
+	signer, cert := generateTestCert(t)
+	s := &SAMLPlugin{}
+	s.setIDPMetadata(testIDPMetadata(cert))
+
+	req := logoutRequestHTTPRequest(t, signedLogoutRequestXML(t, signer, "_req1", "alice", "session-1"))
+
+	logoutReq, err := s.parseLogoutRequest(req)
+	if err != nil {
+		t.Fatalf("parseLogoutRequest: %s", err)
+	}
+	if logoutReq.ID != "_req1" {
+		t.Errorf("ID = %q, want %q", logoutReq.ID, "_req1")
+	}
+	if logoutReq.NameID.Value != "alice" {
+		t.Errorf("NameID = %q, want %q", logoutReq.NameID.Value, "alice")
+	}
+	if logoutReq.SessionIndex != "session-1" {
+		t.Errorf("SessionIndex = %q, want %q", logoutReq.SessionIndex, "session-1")
+	}
+}
+
+func TestParseLogoutRequestUntrustedSigner(t *testing.T) {
+	signer, _ := generateTestCert(t)
+	_, trustedCert := generateTestCert(t)
+	s := &SAMLPlugin{}
+	s.setIDPMetadata(testIDPMetadata(trustedCert))
+
+	req := logoutRequestHTTPRequest(t, signedLogoutRequestXML(t, signer, "_req2", "alice", "session-1"))
+
+	if _, err := s.parseLogoutRequest(req); err == nil {
+		t.Error("expected an error validating a LogoutRequest signed by an untrusted key")
+	}
+}
+
+func TestParseLogoutRequestMissingSignature(t *testing.T) {
+	_, cert := generateTestCert(t)
+	s := &SAMLPlugin{}
+	s.setIDPMetadata(testIDPMetadata(cert))
+
+	doc := etree.NewDocument()
+	doc.SetRoot(unsignedLogoutRequestElement("_req3", "alice", "session-1"))
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatalf("serializing unsigned LogoutRequest: %s", err)
+	}
+
+	req := logoutRequestHTTPRequest(t, raw)
+	if _, err := s.parseLogoutRequest(req); err == nil {
+		t.Error("expected an error validating an unsigned LogoutRequest")
+	}
+}
+
+func TestLogoutRequestMatchesSession(t *testing.T) {
+	logoutReq := &incomingLogoutRequest{SessionIndex: "session-1"}
+	logoutReq.NameID.Value = "alice"
+
+	cases := []struct {
+		name  string
+		token *AuthorizationToken
+		want  bool
+	}{
+		{"nil token", nil, false},
+		{"matching subject and session", &AuthorizationToken{Subject: "alice", SessionIndex: "session-1"}, true},
+		{"different subject", &AuthorizationToken{Subject: "bob", SessionIndex: "session-1"}, false},
+		{"different session", &AuthorizationToken{Subject: "alice", SessionIndex: "session-2"}, false},
+	}
+	for _, c := range cases {
+		if got := logoutRequestMatchesSession(c.token, logoutReq); got != c.want {
+			t.Errorf("%s: logoutRequestMatchesSession() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestResponseBindingForMatchesRequestMethod(t *testing.T) {
+	postReq := httptest.NewRequest(http.MethodPost, "/saml/slo", nil)
+	if got := responseBindingFor(postReq); got != saml.HTTPPostBinding {
+		t.Errorf("responseBindingFor(POST) = %q, want %q", got, saml.HTTPPostBinding)
+	}
+
+	getReq := httptest.NewRequest(http.MethodGet, "/saml/slo", nil)
+	if got := responseBindingFor(getReq); got != saml.HTTPRedirectBinding {
+		t.Errorf("responseBindingFor(GET) = %q, want %q", got, saml.HTTPRedirectBinding)
+	}
+}
@@ -2,11 +2,12 @@
 package samlplugin
 
 import (
-	"crypto/x509"
+	"crypto/rsa"
 	"encoding/base64"
 	"fmt"
 	"net/http"
-	"strings"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/crewjam/saml"
@@ -52,12 +53,47 @@ type SAMLPlugin struct {
 	TokenMaxAge       time.Duration
 	ClientState       ClientState
 	ClientToken       ClientToken
-	Map               map[string][]string
-	next              httpserver.Handler
+	// Map lists the protected (and passthrough) routes served ahead of
+	// s.next, evaluated in order; the first Route whose Prefix matches
+	// wins. See Route.
+	Map []*Route
+	// SessionStore, if set, holds authorized sessions server-side so the
+	// ClientToken cookie only needs to carry an opaque session ID. When
+	// nil (the default), the full signed claims are stored in the cookie
+	// as before. Configured via the `session_store` Caddyfile directive.
+	SessionStore SessionStore
+	// SLOURL is the path at which the plugin serves the Single Logout
+	// endpoint, handling both SP-initiated LogoutResponses and
+	// IdP-initiated LogoutRequests. See logout.go.
+	SLOURL url.URL
+	// Binding forces the SAML binding used to initiate authentication and
+	// logout requests: "" picks HTTP-Redirect and falls back to
+	// HTTP-POST if the IdP doesn't advertise a redirect location (the
+	// historical behavior); saml.HTTPRedirectBinding or
+	// saml.HTTPPostBinding pin the binding outright. Pinning is useful
+	// for IdPs that advertise a redirect binding but reject the
+	// resulting long, complex requests.
+	Binding string
+	// JWTKey, if set, is the RSA key used to sign and verify the JWTs
+	// this plugin issues (relay-state and session cookies), in place of
+	// the SAML service provider's own key. JWTKeyID, if set, is stamped
+	// into the token's "kid" header so JWTVerificationKeys can identify
+	// which key to verify against during a rotation.
+	JWTKey   *rsa.PrivateKey
+	JWTKeyID string
+	// JWTVerificationKeys holds previously-retired signing keys, keyed by
+	// the kid they were issued under, so that sessions signed before a
+	// JWTKey rotation keep verifying until they expire on their own.
+	JWTVerificationKeys map[string]*rsa.PublicKey
+	next                httpserver.Handler
+	// idpMetadataMu guards every read and write of
+	// s.ServiceProvider.IDPMetadata that goes through idpMetadata/
+	// setIDPMetadata, so a WatchIDPMetadata refresh can't race our own
+	// code (e.g. getIDPSigningCerts) reading it mid-swap. See
+	// idp_metadata.go.
+	idpMetadataMu sync.RWMutex
 }
 
-var jwtSigningMethod = jwt.SigningMethodHS256
-
 func randomBytes(n int) []byte {
 	rv := make([]byte, n)
 	if _, err := saml.RandReader.Read(rv); err != nil {
@@ -80,9 +116,15 @@ func (s *SAMLPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, err
 		return 200, nil
 	}
 
+	if r.URL.Path == s.SLOURL.Path {
+		s.handleSLO(w, r)
+		return s.next.ServeHTTP(w, r)
+	}
+
 	if r.URL.Path == s.ServiceProvider.AcsURL.Path {
 		r.ParseForm()
-		assertion, err := s.ServiceProvider.ParseResponse(r, s.getPossibleRequestIDs(r))
+		sp := s.serviceProvider()
+		assertion, err := sp.ParseResponse(r, s.getPossibleRequestIDs(r))
 		if err != nil {
 			if parseErr, ok := err.(*saml.InvalidResponseError); ok {
 				s.ServiceProvider.Logger.Printf("RESPONSE: ===\n%s\n===\nNOW: %s\nERROR: %s",
@@ -96,47 +138,82 @@ func (s *SAMLPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, err
 		return s.next.ServeHTTP(w, r)
 	}
 
-	for k, v := range s.Map {
-		if strings.HasPrefix(r.URL.Path, k) {
-			if token := s.GetAuthorizationToken(r); token != nil {
-				r = r.WithContext(WithToken(r.Context(), token))
-				if isAuthorized(v, token) {
-					setHeaders(r, token)
-					if dumpAttributes(v) {
-						spew.Fdump(w, token)
-						return 200, nil
-					}
-					return s.next.ServeHTTP(w, r)
-				} else {
-					return 403, nil
-				}
-			} else {
-				s.RequireAccount(w, r)
+	if route := matchRoute(s.Map, r.URL.Path); route != nil {
+		token := s.GetAuthorizationToken(r)
+		if token == nil {
+			if route.Passthrough {
+				return s.next.ServeHTTP(w, r)
 			}
+			s.RequireAccountHandler(w, r)
+			return 200, nil
+		}
+
+		r = r.WithContext(WithToken(r.Context(), token))
+		if route.Require != nil && !route.Require.Eval(token.Attributes) {
+			return 403, nil
+		}
+		for _, header := range route.Headers {
+			header.Apply(r, token.Attributes)
 		}
+		if route.Dump {
+			spew.Fdump(w, token)
+			return 200, nil
+		}
+		return s.next.ServeHTTP(w, r)
 	}
 	return s.next.ServeHTTP(w, r)
 }
 
 // RequireAccount is HTTP middleware that requires that each request be
-// associated with a valid session. If the request is not associated with a valid
-// session, then rather than serve the request, the middlware redirects the user
-// to start the SAML auth flow.
-func (s *SAMLPlugin) RequireAccount(w http.ResponseWriter, r *http.Request) {
+// associated with a valid session. If the request is not associated with a
+// valid session, then rather than serve the request, the middleware
+// redirects the user to start the SAML auth flow via RequireAccountHandler.
+//
+// For example:
+//
+//	goji.Use(m.RequireAccount)
+func (s *SAMLPlugin) RequireAccount(handler http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if token := s.GetAuthorizationToken(r); token != nil {
+			r = r.WithContext(WithToken(r.Context(), token))
+			handler.ServeHTTP(w, r)
+			return
+		}
+		s.RequireAccountHandler(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ssoBindingLocation resolves the binding and bindng location to use for a
+// new authentication request, honoring s.Binding when set and otherwise
+// preferring HTTP-Redirect, falling back to HTTP-POST.
+func (s *SAMLPlugin) ssoBindingLocation() (binding, bindingLocation string) {
+	if s.Binding != "" {
+		return s.Binding, s.ServiceProvider.GetSSOBindingLocation(s.Binding)
+	}
+	binding = saml.HTTPRedirectBinding
+	bindingLocation = s.ServiceProvider.GetSSOBindingLocation(binding)
+	if bindingLocation == "" {
+		binding = saml.HTTPPostBinding
+		bindingLocation = s.ServiceProvider.GetSSOBindingLocation(binding)
+	}
+	return binding, bindingLocation
+}
+
+// RequireAccountHandler redirects the browser to start the SAML auth flow.
+// It is the standalone handler form of RequireAccount, for callers that want
+// to compose the redirect step into their own auth chains rather than
+// relying on the path-map dispatch inside ServeHTTP.
+func (s *SAMLPlugin) RequireAccountHandler(w http.ResponseWriter, r *http.Request) {
 	// If we try to redirect when the original request is the ACS URL we'll
 	// end up in a loop. This is a programming error, so we panic here. In
 	// general this means a 500 to the user, which is preferable to a
 	// redirect loop.
 	if r.URL.Path == s.ServiceProvider.AcsURL.Path {
-		panic("don't wrap SAMLPlugin with RequireAccount")
+		panic("don't wrap SAMLPlugin with RequireAccountHandler")
 	}
 
-	binding := saml.HTTPRedirectBinding
-	bindingLocation := s.ServiceProvider.GetSSOBindingLocation(binding)
-	if bindingLocation == "" {
-		binding = saml.HTTPPostBinding
-		bindingLocation = s.ServiceProvider.GetSSOBindingLocation(binding)
-	}
+	binding, bindingLocation := s.ssoBindingLocation()
 
 	req, err := s.ServiceProvider.MakeAuthenticationRequest(bindingLocation)
 	if err != nil {
@@ -149,12 +226,10 @@ func (s *SAMLPlugin) RequireAccount(w http.ResponseWriter, r *http.Request) {
 	// we set a cookie that corresponds to the state
 	relayState := base64.URLEncoding.EncodeToString(randomBytes(42))
 
-	secretBlock := x509.MarshalPKCS1PrivateKey(s.ServiceProvider.Key)
-	state := jwt.New(jwtSigningMethod)
-	claims := state.Claims.(jwt.MapClaims)
-	claims["id"] = req.ID
-	claims["uri"] = r.URL.String()
-	signedState, err := state.SignedString(secretBlock)
+	signedState, err := s.signJWT(jwt.MapClaims{
+		"id":  req.ID,
+		"uri": r.URL.String(),
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -184,13 +259,7 @@ func (s *SAMLPlugin) RequireAccount(w http.ResponseWriter, r *http.Request) {
 func (s *SAMLPlugin) getPossibleRequestIDs(r *http.Request) []string {
 	rv := []string{}
 	for _, value := range s.ClientState.GetStates(r) {
-		jwtParser := jwt.Parser{
-			ValidMethods: []string{jwtSigningMethod.Name},
-		}
-		token, err := jwtParser.Parse(value, func(t *jwt.Token) (interface{}, error) {
-			secretBlock := x509.MarshalPKCS1PrivateKey(s.ServiceProvider.Key)
-			return secretBlock, nil
-		})
+		token, err := restrictedJWTParser.Parse(value, s.jwtKeyFunc)
 		if err != nil || !token.Valid {
 			s.ServiceProvider.Logger.Printf("... invalid token %s", err)
 			continue
@@ -211,8 +280,6 @@ func (s *SAMLPlugin) getPossibleRequestIDs(r *http.Request) []string {
 // It sets a cookie that contains a signed JWT containing the assertion attributes.
 // It then redirects the user's browser to the original URL contained in RelayState.
 func (s *SAMLPlugin) Authorize(w http.ResponseWriter, r *http.Request, assertion *saml.Assertion) {
-	secretBlock := x509.MarshalPKCS1PrivateKey(s.ServiceProvider.Key)
-
 	redirectURI := "/"
 	if relayState := r.Form.Get("RelayState"); relayState != "" {
 		stateValue := s.ClientState.GetState(r, relayState)
@@ -222,12 +289,7 @@ func (s *SAMLPlugin) Authorize(w http.ResponseWriter, r *http.Request, assertion
 			return
 		}
 
-		jwtParser := jwt.Parser{
-			ValidMethods: []string{jwtSigningMethod.Name},
-		}
-		state, err := jwtParser.Parse(stateValue, func(t *jwt.Token) (interface{}, error) {
-			return secretBlock, nil
-		})
+		state, err := restrictedJWTParser.Parse(stateValue, s.jwtKeyFunc)
 		if err != nil || !state.Valid {
 			s.ServiceProvider.Logger.Printf("Cannot decode state JWT: %s (%s)", err, stateValue)
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
@@ -251,6 +313,9 @@ func (s *SAMLPlugin) Authorize(w http.ResponseWriter, r *http.Request, assertion
 			claims.StandardClaims.Subject = nameID.Value
 		}
 	}
+	for _, authnStatement := range assertion.AuthnStatements {
+		claims.SessionIndex = authnStatement.SessionIndex
+	}
 	for _, attributeStatement := range assertion.AttributeStatements {
 		claims.Attributes = map[string][]string{}
 		for _, attr := range attributeStatement.Attributes {
@@ -263,8 +328,7 @@ func (s *SAMLPlugin) Authorize(w http.ResponseWriter, r *http.Request, assertion
 			}
 		}
 	}
-	signedToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		claims).SignedString(secretBlock)
+	signedToken, err := s.signSessionCookie(&claims)
 	if err != nil {
 		panic(err)
 	}
@@ -273,6 +337,37 @@ func (s *SAMLPlugin) Authorize(w http.ResponseWriter, r *http.Request, assertion
 	http.Redirect(w, r, redirectURI, http.StatusFound)
 }
 
+// signSessionCookie produces the value to be stored in the ClientToken
+// cookie. When s.SessionStore is configured, the full claims (including
+// attributes) are persisted server-side and the cookie carries only a
+// signed, opaque session ID; this keeps attributes off the client and
+// allows SLO to revoke a session immediately via SessionStore.Delete.
+// Without a SessionStore, the cookie carries the full signed claims, as
+// before.
+func (s *SAMLPlugin) signSessionCookie(claims *AuthorizationToken) (string, error) {
+	if s.SessionStore == nil {
+		return s.signJWT(claims)
+	}
+
+	id, err := s.SessionStore.New(claims)
+	if err != nil {
+		return "", err
+	}
+	pointer := sessionCookieClaims{
+		StandardClaims: claims.StandardClaims,
+		SessionID:      id,
+	}
+	return s.signJWT(pointer)
+}
+
+// sessionCookieClaims is the cookie payload used when a SessionStore is
+// configured: just enough to validate the cookie's integrity and audience
+// and to look up the real claims in the store.
+type sessionCookieClaims struct {
+	jwt.StandardClaims
+	SessionID string `json:"sid"`
+}
+
 // IsAuthorized returns true if the request has already been authorized.
 //
 // Note: This function is retained for compatability. Use GetAuthorizationToken in new code
@@ -291,11 +386,31 @@ func (s *SAMLPlugin) GetAuthorizationToken(r *http.Request) *AuthorizationToken
 		return nil
 	}
 
+	if s.SessionStore != nil {
+		pointer := sessionCookieClaims{}
+		token, err := restrictedJWTParser.ParseWithClaims(tokenStr, &pointer, s.jwtKeyFunc)
+		if err != nil || !token.Valid {
+			s.ServiceProvider.Logger.Printf("ERROR: invalid session cookie: %s", err)
+			return nil
+		}
+		if err := pointer.Valid(); err != nil {
+			s.ServiceProvider.Logger.Printf("ERROR: invalid session cookie claims: %s", err)
+			return nil
+		}
+		if pointer.Audience != s.ServiceProvider.Metadata().EntityID {
+			s.ServiceProvider.Logger.Printf("ERROR: invalid audience: %s", err)
+			return nil
+		}
+		tokenClaims, err := s.SessionStore.Get(pointer.SessionID)
+		if err != nil {
+			s.ServiceProvider.Logger.Printf("ERROR: session not found: %s", err)
+			return nil
+		}
+		return tokenClaims
+	}
+
 	tokenClaims := AuthorizationToken{}
-	token, err := jwt.ParseWithClaims(tokenStr, &tokenClaims, func(t *jwt.Token) (interface{}, error) {
-		secretBlock := x509.MarshalPKCS1PrivateKey(s.ServiceProvider.Key)
-		return secretBlock, nil
-	})
+	token, err := restrictedJWTParser.ParseWithClaims(tokenStr, &tokenClaims, s.jwtKeyFunc)
 	if err != nil || !token.Valid {
 		s.ServiceProvider.Logger.Printf("ERROR: invalid token: %s", err)
 		return nil
@@ -319,9 +434,8 @@ func (s *SAMLPlugin) GetAuthorizationToken(r *http.Request) *AuthorizationToken
 //
 // For example:
 //
-//     goji.Use(m.RequireAccount)
-//     goji.Use(RequireAttributeSAMLPlugin("eduPersonAffiliation", "Staff"))
-//
+//	goji.Use(m.RequireAccount)
+//	goji.Use(RequireAttribute("eduPersonAffiliation", "Staff"))
 func RequireAttribute(name, value string) func(http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,78 @@
+package samlplugin
+
+import (
+	"testing"
+
+	"github.com/crewjam/saml"
+)
+
+func ssoMetadata(endpoints ...saml.Endpoint) *saml.EntityDescriptor {
+	return &saml.EntityDescriptor{
+		IDPSSODescriptors: []saml.IDPSSODescriptor{{SingleSignOnServices: endpoints}},
+	}
+}
+
+func TestSSOBindingLocationPrefersRedirect(t *testing.T) {
+	s := &SAMLPlugin{
+		ServiceProvider: saml.ServiceProvider{
+			IDPMetadata: ssoMetadata(
+				saml.Endpoint{Binding: saml.HTTPRedirectBinding, Location: "https://idp.example.com/sso/redirect"},
+				saml.Endpoint{Binding: saml.HTTPPostBinding, Location: "https://idp.example.com/sso/post"},
+			),
+		},
+	}
+
+	binding, location := s.ssoBindingLocation()
+	if binding != saml.HTTPRedirectBinding {
+		t.Errorf("binding = %q, want %q", binding, saml.HTTPRedirectBinding)
+	}
+	if location != "https://idp.example.com/sso/redirect" {
+		t.Errorf("location = %q, want the redirect endpoint", location)
+	}
+}
+
+func TestSSOBindingLocationFallsBackToPost(t *testing.T) {
+	s := &SAMLPlugin{
+		ServiceProvider: saml.ServiceProvider{
+			IDPMetadata: ssoMetadata(
+				saml.Endpoint{Binding: saml.HTTPPostBinding, Location: "https://idp.example.com/sso/post"},
+			),
+		},
+	}
+
+	binding, location := s.ssoBindingLocation()
+	if binding != saml.HTTPPostBinding {
+		t.Errorf("binding = %q, want %q", binding, saml.HTTPPostBinding)
+	}
+	if location != "https://idp.example.com/sso/post" {
+		t.Errorf("location = %q, want the POST endpoint", location)
+	}
+}
+
+func TestSSOBindingLocationHonorsConfiguredBinding(t *testing.T) {
+	s := &SAMLPlugin{
+		Binding: saml.HTTPPostBinding,
+		ServiceProvider: saml.ServiceProvider{
+			IDPMetadata: ssoMetadata(
+				saml.Endpoint{Binding: saml.HTTPRedirectBinding, Location: "https://idp.example.com/sso/redirect"},
+				saml.Endpoint{Binding: saml.HTTPPostBinding, Location: "https://idp.example.com/sso/post"},
+			),
+		},
+	}
+
+	binding, location := s.ssoBindingLocation()
+	if binding != saml.HTTPPostBinding {
+		t.Errorf("binding = %q, want the explicitly configured %q", binding, saml.HTTPPostBinding)
+	}
+	if location != "https://idp.example.com/sso/post" {
+		t.Errorf("location = %q, want the POST endpoint", location)
+	}
+}
+
+func TestSSOBindingLocationNoUsableEndpoint(t *testing.T) {
+	s := &SAMLPlugin{ServiceProvider: saml.ServiceProvider{IDPMetadata: ssoMetadata()}}
+
+	if _, location := s.ssoBindingLocation(); location != "" {
+		t.Errorf("location = %q, want empty when the IdP offers no SSO endpoint", location)
+	}
+}
@@ -0,0 +1,89 @@
+package samlplugin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HeaderMapping projects a SAML attribute onto an outgoing request header,
+// replacing the ad-hoc setHeaders behavior. It is configured in the
+// Caddyfile as:
+//
+//	header X-User-Email = mail
+//	header X-User-Groups = groups joined ","
+//
+// Without "joined", only the first value of a multi-valued attribute is
+// used.
+type HeaderMapping struct {
+	Header    string
+	Attribute string
+	// Joiner, if non-empty, joins every value of Attribute with this
+	// separator. If empty, only the first value is used.
+	Joiner string
+}
+
+// ParseHeaderMapping parses a single `header` directive line, e.g.
+// `header X-User-Email = mail` or `header X-User-Groups = groups joined ", "`.
+// It reuses authz.go's tokenizeExpr rather than strings.Fields so that a
+// quoted joiner containing whitespace is recognized as a single token.
+func ParseHeaderMapping(line string) (HeaderMapping, error) {
+	tokens := tokenizeExpr(line)
+	if len(tokens) < 4 || !strings.EqualFold(tokens[0], "header") || tokens[2] != "=" {
+		return HeaderMapping{}, fmt.Errorf("malformed header mapping: %q", line)
+	}
+	m := HeaderMapping{Header: tokens[1], Attribute: tokens[3]}
+	if len(tokens) > 4 {
+		if !strings.EqualFold(tokens[4], "joined") || len(tokens) != 6 {
+			return HeaderMapping{}, fmt.Errorf("malformed header mapping: %q", line)
+		}
+		m.Joiner = unquote(tokens[5])
+	}
+	return m, nil
+}
+
+// Apply sets r's header from attrs, if the attribute is present.
+func (m HeaderMapping) Apply(r *http.Request, attrs map[string][]string) {
+	values := attrs[m.Attribute]
+	if len(values) == 0 {
+		return
+	}
+	if m.Joiner != "" {
+		r.Header.Set(m.Header, strings.Join(values, m.Joiner))
+		return
+	}
+	r.Header.Set(m.Header, values[0])
+}
+
+// Route describes how SAMLPlugin.ServeHTTP handles requests whose path
+// begins with Prefix. Routes are evaluated in order and the first matching
+// Prefix wins, replacing the old map[string][]string Map (whose iteration
+// order was undefined).
+type Route struct {
+	Prefix string
+	// Require, if non-nil, must evaluate true against the authenticated
+	// attributes for the request to be let through; nil requires only
+	// that the request be authenticated.
+	Require AttrExpr
+	// Headers projects attributes onto request headers before the
+	// request reaches the next handler.
+	Headers []HeaderMapping
+	// Passthrough lets unauthenticated requests through unmodified,
+	// for public sub-paths nested under an otherwise protected prefix.
+	Passthrough bool
+	// Dump, if set, renders the authenticated token as a debug dump
+	// instead of invoking the next handler. Equivalent to the old magic
+	// dump-attribute value.
+	Dump bool
+}
+
+// matchRoute returns the first Route whose Prefix is a prefix of path, or
+// nil if none match.
+func matchRoute(routes []*Route, path string) *Route {
+	for _, route := range routes {
+		if strings.HasPrefix(path, route.Prefix) {
+			return route
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package samlplugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHeaderMappingSimple(t *testing.T) {
+	m, err := ParseHeaderMapping(`header X-User-Email = mail`)
+	if err != nil {
+		t.Fatalf("ParseHeaderMapping: %s", err)
+	}
+	want := HeaderMapping{Header: "X-User-Email", Attribute: "mail"}
+	if m != want {
+		t.Errorf("ParseHeaderMapping = %+v, want %+v", m, want)
+	}
+}
+
+func TestParseHeaderMappingJoined(t *testing.T) {
+	m, err := ParseHeaderMapping(`header X-User-Groups = groups joined ", "`)
+	if err != nil {
+		t.Fatalf("ParseHeaderMapping: %s", err)
+	}
+	want := HeaderMapping{Header: "X-User-Groups", Attribute: "groups", Joiner: ", "}
+	if m != want {
+		t.Errorf("ParseHeaderMapping = %+v, want %+v", m, want)
+	}
+}
+
+func TestParseHeaderMappingMissingAttribute(t *testing.T) {
+	if _, err := ParseHeaderMapping(`header X-User-Email =`); err == nil {
+		t.Error("expected an error for a directive missing the attribute name")
+	}
+}
+
+func TestParseHeaderMappingMalformed(t *testing.T) {
+	for _, line := range []string{
+		``,
+		`header X-User-Email`,
+		`header X-User-Email mail`,
+		`nonsense X-User-Email = mail`,
+		`header X-User-Groups = groups joined`,
+		`header X-User-Groups = groups joined "," extra`,
+	} {
+		if _, err := ParseHeaderMapping(line); err == nil {
+			t.Errorf("ParseHeaderMapping(%q): expected an error, got nil", line)
+		}
+	}
+}
+
+func TestMatchRouteReturnsFirstMatchingPrefix(t *testing.T) {
+	routes := []*Route{
+		{Prefix: "/app/admin"},
+		{Prefix: "/app"},
+	}
+	got := matchRoute(routes, "/app/admin/users")
+	if got != routes[0] {
+		t.Errorf("matchRoute returned %+v, want the most specific route listed first", got)
+	}
+}
+
+func TestMatchRouteFallsBackToBroaderPrefix(t *testing.T) {
+	routes := []*Route{
+		{Prefix: "/app/admin"},
+		{Prefix: "/app"},
+	}
+	got := matchRoute(routes, "/app/dashboard")
+	if got != routes[1] {
+		t.Errorf("matchRoute returned %+v, want %+v", got, routes[1])
+	}
+}
+
+func TestMatchRouteNoMatch(t *testing.T) {
+	routes := []*Route{{Prefix: "/app"}}
+	if got := matchRoute(routes, "/other"); got != nil {
+		t.Errorf("matchRoute returned %+v, want nil", got)
+	}
+}
+
+func TestHeaderMappingApply(t *testing.T) {
+	m := HeaderMapping{Header: "X-User-Groups", Attribute: "groups", Joiner: ","}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.Apply(req, map[string][]string{"groups": {"eng", "ops"}})
+	if got := req.Header.Get("X-User-Groups"); got != "eng,ops" {
+		t.Errorf("Header = %q, want %q", got, "eng,ops")
+	}
+}
+
+func TestHeaderMappingApplyWithoutJoinerUsesFirstValue(t *testing.T) {
+	m := HeaderMapping{Header: "X-User-Email", Attribute: "mail"}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.Apply(req, map[string][]string{"mail": {"alice@example.com", "alice@other.com"}})
+	if got := req.Header.Get("X-User-Email"); got != "alice@example.com" {
+		t.Errorf("Header = %q, want %q", got, "alice@example.com")
+	}
+}
+
+func TestHeaderMappingApplyMissingAttributeLeavesHeaderUnset(t *testing.T) {
+	m := HeaderMapping{Header: "X-User-Email", Attribute: "mail"}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.Apply(req, map[string][]string{})
+	if got := req.Header.Get("X-User-Email"); got != "" {
+		t.Errorf("Header = %q, want unset", got)
+	}
+}
@@ -0,0 +1,108 @@
+package samlplugin
+
+import (
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errSessionNotFound is returned by SessionStore.Get when the given session
+// ID is unknown or has expired.
+var errSessionNotFound = errors.New("samlplugin: session not found")
+
+// SessionStore persists authorized sessions server-side so that the browser
+// cookie managed by ClientToken only needs to carry an opaque session ID
+// rather than the full set of SAML attributes. Implementations must be safe
+// for concurrent use.
+type SessionStore interface {
+	// New persists the given token under a freshly generated session ID
+	// and returns that ID.
+	New(token *AuthorizationToken) (id string, err error)
+	// Get returns the token previously stored under id, or an error if no
+	// such session exists (including an expired one).
+	Get(id string) (*AuthorizationToken, error)
+	// Delete removes the session, if any. It is not an error to delete a
+	// session that does not exist.
+	Delete(id string) error
+}
+
+// MemorySessionStore is the default SessionStore, holding sessions in a
+// process-local map. It is appropriate for single-instance deployments and
+// for development; multi-instance deployments should configure a shared
+// backend (e.g. Redis) via the `session_store` Caddyfile directive instead.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	token   *AuthorizationToken
+	expires time.Time
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: map[string]memorySession{},
+	}
+}
+
+func (m *MemorySessionStore) New(token *AuthorizationToken) (string, error) {
+	id := base64.URLEncoding.EncodeToString(randomBytes(32))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[id] = memorySession{
+		token:   token,
+		expires: time.Unix(token.ExpiresAt, 0),
+	}
+	return id, nil
+}
+
+func (m *MemorySessionStore) Get(id string) (*AuthorizationToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok || time.Now().After(session.expires) {
+		return nil, errSessionNotFound
+	}
+	return session.token, nil
+}
+
+func (m *MemorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// Reap deletes all sessions whose ExpiresAt has passed, as of now.
+func (m *MemorySessionStore) Reap(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, session := range m.sessions {
+		if now.After(session.expires) {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+// StartReaper launches a goroutine that calls Reap on the given interval
+// until stop is closed. Callers configuring a MemorySessionStore from the
+// Caddyfile should run this alongside TokenMaxAge so abandoned sessions
+// don't accumulate forever.
+func (m *MemorySessionStore) StartReaper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				m.Reap(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
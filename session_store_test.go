@@ -0,0 +1,97 @@
+package samlplugin
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStoreNewGetDelete(t *testing.T) {
+	store := NewMemorySessionStore()
+	token := &AuthorizationToken{ExpiresAt: time.Now().Add(time.Hour).Unix()}
+
+	id, err := store.New(token)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	got, err := store.Get(id)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if got != token {
+		t.Error("Get returned a different token than was stored")
+	}
+
+	if err := store.Delete(id); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if _, err := store.Get(id); err != errSessionNotFound {
+		t.Errorf("Get after Delete: err = %v, want errSessionNotFound", err)
+	}
+}
+
+func TestMemorySessionStoreGetUnknown(t *testing.T) {
+	store := NewMemorySessionStore()
+	if _, err := store.Get("nonexistent"); err != errSessionNotFound {
+		t.Errorf("Get: err = %v, want errSessionNotFound", err)
+	}
+}
+
+func TestMemorySessionStoreDeleteUnknownIsNoop(t *testing.T) {
+	store := NewMemorySessionStore()
+	if err := store.Delete("nonexistent"); err != nil {
+		t.Errorf("Delete of unknown id: err = %v, want nil", err)
+	}
+}
+
+func TestMemorySessionStoreReapExpiresSessions(t *testing.T) {
+	store := NewMemorySessionStore()
+	now := time.Now()
+
+	expiredID, err := store.New(&AuthorizationToken{ExpiresAt: now.Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	liveID, err := store.New(&AuthorizationToken{ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	store.Reap(now)
+
+	if _, err := store.Get(expiredID); err != errSessionNotFound {
+		t.Errorf("expired session survived Reap: err = %v", err)
+	}
+	if _, err := store.Get(liveID); err != nil {
+		t.Errorf("live session was reaped: %s", err)
+	}
+}
+
+// TestMemorySessionStoreConcurrentAccess exercises New/Get/Delete/Reap from
+// many goroutines at once under -race, guarding against the reaper racing
+// concurrent session access through an unguarded map read or write.
+func TestMemorySessionStoreConcurrentAccess(t *testing.T) {
+	store := NewMemorySessionStore()
+	stop := make(chan struct{})
+	store.StartReaper(time.Millisecond, stop)
+	defer close(stop)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id, err := store.New(&AuthorizationToken{ExpiresAt: time.Now().Add(time.Millisecond).Unix()})
+				if err != nil {
+					t.Errorf("New: %s", err)
+					return
+				}
+				store.Get(id)
+				store.Delete(id)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,121 @@
+package samlplugin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// TenantSelector extracts the tenant discriminator from an incoming
+// request, e.g. from the hostname, a URL path prefix, or a query
+// parameter chosen by a discovery page. It returns "" when no tenant can
+// be inferred, in which case MultiTenantPlugin passes the request on to its
+// next handler; the discovery page is served only at DiscoveryPath.
+type TenantSelector func(r *http.Request) string
+
+// HostTenantSelector selects the tenant by hostname, e.g. for deployments
+// that give each customer their own subdomain.
+func HostTenantSelector(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// PrefixTenantSelector selects the tenant from the first path segment
+// beneath prefix, e.g. with prefix "/saml/" a request for
+// "/saml/acme/metadata" selects tenant "acme".
+func PrefixTenantSelector(prefix string) TenantSelector {
+	return func(r *http.Request) string {
+		rest := strings.TrimPrefix(r.URL.Path, prefix)
+		if rest == r.URL.Path {
+			return ""
+		}
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			return rest[:i]
+		}
+		return rest
+	}
+}
+
+// QueryTenantSelector selects the tenant from the named query parameter,
+// as chosen by a discovery page rendered by MultiTenantPlugin.
+func QueryTenantSelector(param string) TenantSelector {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(param)
+	}
+}
+
+// MultiTenantPlugin fronts more than one *SAMLPlugin, each configured for a
+// single IdP/SP pair, and dispatches each request to the right one based on
+// Selector. Each tenant's SAMLPlugin is expected to already have its
+// MetadataURL, AcsURL and SLOURL scoped beneath that tenant (e.g.
+// /saml/<tenant>/metadata), so routing a request to the right SAMLPlugin is
+// sufficient to route it to the right endpoint within that plugin.
+//
+// Because every tenant has its own ServiceProvider, JWT audiences and
+// relay-state cookies are namespaced per tenant for free: GetAuthorizationToken
+// and RequireAccountHandler both operate against the selected tenant's
+// ServiceProvider alone.
+type MultiTenantPlugin struct {
+	Tenants  map[string]*SAMLPlugin
+	Selector TenantSelector
+	// DiscoveryPath is the single path at which the tenant-chooser page
+	// is served when Selector can't infer a tenant, e.g. "/saml/discovery".
+	// Every other request with no inferable tenant falls through to next,
+	// since MultiTenantPlugin only owns the SAML-related paths, not the
+	// whole host.
+	DiscoveryPath string
+	// Discovery renders the tenant-chooser page shown for DiscoveryPath.
+	// If nil, a minimal default listing of tenant names is served instead.
+	Discovery http.Handler
+	next      httpserver.Handler
+}
+
+// ServeHTTP implements httpserver.Handler, dispatching to the SAMLPlugin
+// selected for the request's tenant. Requests with no inferable tenant or
+// an unrecognized one fall through to next rather than being swallowed,
+// except for DiscoveryPath, which always renders the tenant chooser.
+func (m *MultiTenantPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+	if m.DiscoveryPath != "" && r.URL.Path == m.DiscoveryPath {
+		m.serveDiscovery(w, r)
+		return 200, nil
+	}
+
+	tenant := m.Selector(r)
+	if tenant == "" {
+		return m.next.ServeHTTP(w, r)
+	}
+
+	plugin, ok := m.Tenants[tenant]
+	if !ok {
+		return m.next.ServeHTTP(w, r)
+	}
+
+	return plugin.ServeHTTP(w, r)
+}
+
+func (m *MultiTenantPlugin) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	if m.Discovery != nil {
+		m.Discovery.ServeHTTP(w, r)
+		return
+	}
+
+	names := make([]string, 0, len(m.Tenants))
+	for name := range m.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Add("Content-type", "text/html")
+	fmt.Fprintln(w, `<!DOCTYPE html><html><body><ul>`)
+	for _, name := range names {
+		fmt.Fprintf(w, `<li><a href="?tenant=%s">%s</a></li>`, name, name)
+	}
+	fmt.Fprintln(w, `</ul></body></html>`)
+}
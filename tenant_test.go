@@ -0,0 +1,113 @@
+package samlplugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type stubHandler struct {
+	called bool
+}
+
+func (h *stubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
+	h.called = true
+	return 200, nil
+}
+
+func TestMultiTenantPluginDispatchesToSelectedTenant(t *testing.T) {
+	tenantNext := &stubHandler{}
+	m := &MultiTenantPlugin{
+		Tenants: map[string]*SAMLPlugin{
+			"acme": {next: tenantNext},
+		},
+		Selector: QueryTenantSelector("tenant"),
+		next:     &stubHandler{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/saml/acs?tenant=acme", nil)
+	w := httptest.NewRecorder()
+	if _, err := m.ServeHTTP(w, req); err != nil {
+		t.Fatalf("ServeHTTP: %s", err)
+	}
+	if !tenantNext.called {
+		t.Error("expected request to reach the selected tenant's SAMLPlugin")
+	}
+}
+
+func TestMultiTenantPluginFallsThroughOnEmptyTenant(t *testing.T) {
+	next := &stubHandler{}
+	m := &MultiTenantPlugin{
+		Tenants:  map[string]*SAMLPlugin{"acme": {next: &stubHandler{}}},
+		Selector: QueryTenantSelector("tenant"),
+		next:     next,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/saml/acs", nil)
+	w := httptest.NewRecorder()
+	if _, err := m.ServeHTTP(w, req); err != nil {
+		t.Fatalf("ServeHTTP: %s", err)
+	}
+	if !next.called {
+		t.Error("expected a request with no inferable tenant to fall through to next")
+	}
+}
+
+func TestMultiTenantPluginFallsThroughOnUnknownTenant(t *testing.T) {
+	next := &stubHandler{}
+	m := &MultiTenantPlugin{
+		Tenants:  map[string]*SAMLPlugin{"acme": {next: &stubHandler{}}},
+		Selector: QueryTenantSelector("tenant"),
+		next:     next,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/saml/acs?tenant=unknown", nil)
+	w := httptest.NewRecorder()
+	if _, err := m.ServeHTTP(w, req); err != nil {
+		t.Fatalf("ServeHTTP: %s", err)
+	}
+	if !next.called {
+		t.Error("expected a request with an unrecognized tenant to fall through to next")
+	}
+}
+
+func TestMultiTenantPluginServesDiscoveryPath(t *testing.T) {
+	next := &stubHandler{}
+	m := &MultiTenantPlugin{
+		Tenants:       map[string]*SAMLPlugin{"acme": {next: &stubHandler{}}},
+		Selector:      QueryTenantSelector("tenant"),
+		DiscoveryPath: "/saml/discovery",
+		next:          next,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/saml/discovery", nil)
+	w := httptest.NewRecorder()
+	if _, err := m.ServeHTTP(w, req); err != nil {
+		t.Fatalf("ServeHTTP: %s", err)
+	}
+	if next.called {
+		t.Error("discovery path should not fall through to next")
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("discovery response code = %d, want 200", w.Code)
+	}
+}
+
+func TestPrefixTenantSelector(t *testing.T) {
+	selector := PrefixTenantSelector("/saml/")
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/saml/acme/metadata", "acme"},
+		{"/saml/acme", "acme"},
+		{"/other/path", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if got := selector(req); got != c.want {
+			t.Errorf("PrefixTenantSelector(%q) = %q, want %q", c.path, got, c.want)
+		}
+	}
+}